@@ -0,0 +1,411 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package procfs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/procfs/internal/util"
+)
+
+// cgroupMemoryMax is the sentinel value the kernel writes into memory.max,
+// memory.high and memory.swap.max when no limit is configured.
+const cgroupMemoryMax = "max"
+
+// defaultCgroupMountPoint is where the unified (cgroup v2) hierarchy is
+// mounted on most modern distributions.
+const defaultCgroupMountPoint = "/sys/fs/cgroup"
+
+// CgroupMemoryStat holds the counters parsed out of a cgroup v2
+// memory.stat file. Every cgroup v2 memory.stat value is already
+// aggregated over the cgroup's subtree, so these fields are implicitly
+// the "recursive" figures the kernel documents.
+type CgroupMemoryStat struct {
+	Anon                  *uint64 `json:"anon"`
+	File                  *uint64 `json:"file"`
+	KernelStack           *uint64 `json:"kernel_stack"`
+	Slab                  *uint64 `json:"slab"`
+	Sock                  *uint64 `json:"sock"`
+	Shmem                 *uint64 `json:"shmem"`
+	FileMapped            *uint64 `json:"file_mapped"`
+	FileDirty             *uint64 `json:"file_dirty"`
+	FileWriteback         *uint64 `json:"file_writeback"`
+	AnonTHP               *uint64 `json:"anon_thp"`
+	InactiveAnon          *uint64 `json:"inactive_anon"`
+	ActiveAnon            *uint64 `json:"active_anon"`
+	InactiveFile          *uint64 `json:"inactive_file"`
+	ActiveFile            *uint64 `json:"active_file"`
+	Unevictable           *uint64 `json:"unevictable"`
+	SlabReclaimable       *uint64 `json:"slab_reclaimable"`
+	SlabUnreclaimable     *uint64 `json:"slab_unreclaimable"`
+	Pgfault               *uint64 `json:"pgfault"`
+	Pgmajfault            *uint64 `json:"pgmajfault"`
+	Pgrefill              *uint64 `json:"pgrefill"`
+	Pgscan                *uint64 `json:"pgscan"`
+	Pgsteal               *uint64 `json:"pgsteal"`
+	Pgactivate            *uint64 `json:"pgactivate"`
+	Pgdeactivate          *uint64 `json:"pgdeactivate"`
+	PglazyfreeCount       *uint64 `json:"pglazyfree"`
+	PglazyfreedCount      *uint64 `json:"pglazyfreed"`
+	WorkingsetRefault     *uint64 `json:"workingset_refault"`
+	WorkingsetActivate    *uint64 `json:"workingset_activate"`
+	WorkingsetNodereclaim *uint64 `json:"workingset_nodereclaim"`
+}
+
+func (stats *CgroupMemoryStat) parse(line string) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return
+	}
+	vp := util.NewValueParser(parts[1])
+
+	switch parts[0] {
+	case "anon":
+		stats.Anon = vp.PUInt64()
+	case "file":
+		stats.File = vp.PUInt64()
+	case "kernel_stack":
+		stats.KernelStack = vp.PUInt64()
+	case "slab":
+		stats.Slab = vp.PUInt64()
+	case "sock":
+		stats.Sock = vp.PUInt64()
+	case "shmem":
+		stats.Shmem = vp.PUInt64()
+	case "file_mapped":
+		stats.FileMapped = vp.PUInt64()
+	case "file_dirty":
+		stats.FileDirty = vp.PUInt64()
+	case "file_writeback":
+		stats.FileWriteback = vp.PUInt64()
+	case "anon_thp":
+		stats.AnonTHP = vp.PUInt64()
+	case "inactive_anon":
+		stats.InactiveAnon = vp.PUInt64()
+	case "active_anon":
+		stats.ActiveAnon = vp.PUInt64()
+	case "inactive_file":
+		stats.InactiveFile = vp.PUInt64()
+	case "active_file":
+		stats.ActiveFile = vp.PUInt64()
+	case "unevictable":
+		stats.Unevictable = vp.PUInt64()
+	case "slab_reclaimable":
+		stats.SlabReclaimable = vp.PUInt64()
+	case "slab_unreclaimable":
+		stats.SlabUnreclaimable = vp.PUInt64()
+	case "pgfault":
+		stats.Pgfault = vp.PUInt64()
+	case "pgmajfault":
+		stats.Pgmajfault = vp.PUInt64()
+	case "pgrefill":
+		stats.Pgrefill = vp.PUInt64()
+	case "pgscan":
+		stats.Pgscan = vp.PUInt64()
+	case "pgsteal":
+		stats.Pgsteal = vp.PUInt64()
+	case "pgactivate":
+		stats.Pgactivate = vp.PUInt64()
+	case "pgdeactivate":
+		stats.Pgdeactivate = vp.PUInt64()
+	case "pglazyfree":
+		stats.PglazyfreeCount = vp.PUInt64()
+	case "pglazyfreed":
+		stats.PglazyfreedCount = vp.PUInt64()
+	case "workingset_refault":
+		stats.WorkingsetRefault = vp.PUInt64()
+	case "workingset_activate":
+		stats.WorkingsetActivate = vp.PUInt64()
+	case "workingset_nodereclaim":
+		stats.WorkingsetNodereclaim = vp.PUInt64()
+	}
+}
+
+// CgroupMemoryEvents holds the counters parsed out of a cgroup v2
+// memory.events file.
+type CgroupMemoryEvents struct {
+	Low          *uint64 `json:"low"`
+	High         *uint64 `json:"high"`
+	Max          *uint64 `json:"max"`
+	OOM          *uint64 `json:"oom"`
+	OOMKill      *uint64 `json:"oom_kill"`
+	OOMGroupKill *uint64 `json:"oom_group_kill"`
+}
+
+func (events *CgroupMemoryEvents) parse(line string) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return
+	}
+	vp := util.NewValueParser(parts[1])
+
+	switch parts[0] {
+	case "low":
+		events.Low = vp.PUInt64()
+	case "high":
+		events.High = vp.PUInt64()
+	case "max":
+		events.Max = vp.PUInt64()
+	case "oom":
+		events.OOM = vp.PUInt64()
+	case "oom_kill":
+		events.OOMKill = vp.PUInt64()
+	case "oom_group_kill":
+		events.OOMGroupKill = vp.PUInt64()
+	}
+}
+
+// CgroupMemoryNUMAStat holds the per-node breakdown of a single
+// memory.numa_stat category (e.g. "anon" or "file").
+type CgroupMemoryNUMAStat struct {
+	Category string
+	Nodes    map[string]uint64
+}
+
+// CgroupMemory holds the memory controller state of a single cgroup v2,
+// parsed from the memory.current, memory.max, memory.high, memory.low,
+// memory.min, memory.stat, memory.events and memory.numa_stat files
+// under its directory in the unified hierarchy.
+type CgroupMemory struct {
+	// Path is the cgroup path the stats were read from, relative to the
+	// unified hierarchy mount point.
+	Path string
+
+	Current *uint64
+	// Max is nil when memory.max reads "max" (no limit configured).
+	Max *uint64
+	// High is nil when memory.high reads "max" (no limit configured).
+	High *uint64
+	Low  *uint64
+	Min  *uint64
+
+	Stat     *CgroupMemoryStat
+	Events   *CgroupMemoryEvents
+	NUMAStat []CgroupMemoryNUMAStat
+}
+
+// CgroupFS represents the pseudo-filesystem sysfs/cgroup, which provides
+// an interface to the cgroup v2 unified hierarchy.
+type CgroupFS struct {
+	mountPoint string
+}
+
+// NewDefaultCgroupFS returns a new CgroupFS mounted under the default
+// cgroup v2 mount point, autodetecting it from /proc/self/mountinfo.
+func NewDefaultCgroupFS() (CgroupFS, error) {
+	mountPoint, err := discoverCgroupMountPointFrom("/proc/self/mountinfo")
+	if err != nil {
+		mountPoint = defaultCgroupMountPoint
+	}
+	return NewCgroupFS(mountPoint)
+}
+
+// NewCgroupFS returns a new CgroupFS mounted under the given mount point,
+// which must be the mount point of a cgroup2 filesystem.
+func NewCgroupFS(mountPoint string) (CgroupFS, error) {
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		return CgroupFS{}, fmt.Errorf("could not read %q: %s", mountPoint, err)
+	}
+	if !info.IsDir() {
+		return CgroupFS{}, fmt.Errorf("mount point %q is not a directory", mountPoint)
+	}
+	return CgroupFS{mountPoint: mountPoint}, nil
+}
+
+// discoverCgroupMountPointFrom scans the mountinfo file at path (normally
+// /proc/self/mountinfo) for the cgroup2 unified hierarchy mount point.
+func discoverCgroupMountPointFrom(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading mountinfo: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// mountinfo lines have a "-" separator between the first and
+		// second halves; the filesystem type follows it.
+		for i, field := range fields {
+			if field == "-" && i+2 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no cgroup2 mount found in mountinfo")
+}
+
+// SelfMemory returns the CgroupMemory stats for the cgroup the calling
+// process currently belongs to, as reported by /proc/self/cgroup.
+func (fs CgroupFS) SelfMemory() (CgroupMemory, error) {
+	cgroupPath, err := selfCgroupPathFrom("/proc/self/cgroup")
+	if err != nil {
+		return CgroupMemory{}, err
+	}
+	return fs.Memory(cgroupPath)
+}
+
+// selfCgroupPathFrom returns the unified-hierarchy cgroup path recorded in
+// the /proc/<pid>/cgroup-shaped file at path. On a cgroup v2 only system
+// that file has a single line of the form "0::/path/to/cgroup".
+func selfCgroupPathFrom(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %s", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		}
+	}
+	return "", fmt.Errorf("no unified hierarchy entry found in %s", path)
+}
+
+// Memory parses the memory controller files of the cgroup at cgroupPath
+// (relative to the unified hierarchy mount point, e.g. "/" or
+// "/user.slice/user-1000.slice") into a CgroupMemory.
+func (fs CgroupFS) Memory(cgroupPath string) (CgroupMemory, error) {
+	dir := filepath.Join(fs.mountPoint, cgroupPath)
+
+	mem := CgroupMemory{Path: cgroupPath}
+
+	var err error
+	if mem.Current, err = readCgroupMemoryUint64(dir, "memory.current"); err != nil {
+		return CgroupMemory{}, err
+	}
+	if mem.Max, err = readCgroupMemoryUint64OrMax(dir, "memory.max"); err != nil {
+		return CgroupMemory{}, err
+	}
+	if mem.High, err = readCgroupMemoryUint64OrMax(dir, "memory.high"); err != nil {
+		return CgroupMemory{}, err
+	}
+	if mem.Low, err = readCgroupMemoryUint64(dir, "memory.low"); err != nil {
+		return CgroupMemory{}, err
+	}
+	if mem.Min, err = readCgroupMemoryUint64(dir, "memory.min"); err != nil {
+		return CgroupMemory{}, err
+	}
+
+	mem.Stat = &CgroupMemoryStat{}
+	if err := parseCgroupMemoryFileLines(dir, "memory.stat", mem.Stat.parse); err != nil {
+		return CgroupMemory{}, err
+	}
+
+	mem.Events = &CgroupMemoryEvents{}
+	if err := parseCgroupMemoryFileLines(dir, "memory.events", mem.Events.parse); err != nil {
+		return CgroupMemory{}, err
+	}
+
+	numaStat, err := parseCgroupMemoryNUMAStat(dir)
+	if err != nil {
+		return CgroupMemory{}, err
+	}
+	mem.NUMAStat = numaStat
+
+	return mem, nil
+}
+
+func readCgroupMemoryFile(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readCgroupMemoryUint64(dir, name string) (*uint64, error) {
+	value, err := readCgroupMemoryFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewValueParser(value).PUInt64(), nil
+}
+
+// readCgroupMemoryUint64OrMax is for files that may hold the literal
+// value "max" instead of a number, meaning "no limit configured".
+func readCgroupMemoryUint64OrMax(dir, name string) (*uint64, error) {
+	value, err := readCgroupMemoryFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if value == cgroupMemoryMax {
+		return nil, nil
+	}
+	return util.NewValueParser(value).PUInt64(), nil
+}
+
+func parseCgroupMemoryFileLines(dir, name string, parse func(line string)) error {
+	path := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parse(line)
+	}
+	return nil
+}
+
+// parseCgroupMemoryNUMAStat parses memory.numa_stat, whose lines look like:
+//
+//	anon N0=1234 N1=5678
+//	file N0=91 N1=2
+func parseCgroupMemoryNUMAStat(dir string) ([]CgroupMemoryNUMAStat, error) {
+	path := filepath.Join(dir, "memory.numa_stat")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	var stats []CgroupMemoryNUMAStat
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		stat := CgroupMemoryNUMAStat{Category: fields[0], Nodes: map[string]uint64{}}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			node := strings.TrimPrefix(kv[0], "N")
+			if v := util.NewValueParser(kv[1]).PUInt64(); v != nil {
+				stat.Nodes[node] = *v
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
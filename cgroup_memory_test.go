@@ -0,0 +1,163 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package procfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupMemoryFixture(t *testing.T, mountPoint, cgroupPath string) {
+	t.Helper()
+
+	dir := filepath.Join(mountPoint, cgroupPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"memory.current": "104857600\n",
+		"memory.max":     "max\n",
+		"memory.high":    "209715200\n",
+		"memory.low":     "0\n",
+		"memory.min":     "0\n",
+		"memory.stat": "anon 52428800\n" +
+			"file 10485760\n" +
+			"kernel_stack 131072\n" +
+			"slab 2097152\n" +
+			"pgfault 1024\n" +
+			"pgmajfault 3\n",
+		"memory.events": "low 0\n" +
+			"high 2\n" +
+			"max 0\n" +
+			"oom 0\n" +
+			"oom_kill 0\n",
+		"memory.numa_stat": "anon N0=1048576 N1=2097152\n" +
+			"file N0=524288 N1=0\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCgroupFSMemory(t *testing.T) {
+	mountPoint := t.TempDir()
+	writeCgroupMemoryFixture(t, mountPoint, "/user.slice/user-1000.slice")
+
+	fs, err := NewCgroupFS(mountPoint)
+	if err != nil {
+		t.Fatalf("failed to create CgroupFS: %v", err)
+	}
+
+	mem, err := fs.Memory("/user.slice/user-1000.slice")
+	if err != nil {
+		t.Fatalf("failed to parse cgroup memory: %v", err)
+	}
+
+	if mem.Path != "/user.slice/user-1000.slice" {
+		t.Errorf("expected path to be preserved, got %q", mem.Path)
+	}
+	if mem.Current == nil || *mem.Current != 104857600 {
+		t.Errorf("expected Current 104857600, got %v", mem.Current)
+	}
+	if mem.Max != nil {
+		t.Errorf("expected Max to be nil for the \"max\" sentinel, got %v", *mem.Max)
+	}
+	if mem.High == nil || *mem.High != 209715200 {
+		t.Errorf("expected High 209715200, got %v", mem.High)
+	}
+	if mem.Low == nil || *mem.Low != 0 {
+		t.Errorf("expected Low 0, got %v", mem.Low)
+	}
+
+	if mem.Stat == nil || mem.Stat.Anon == nil || *mem.Stat.Anon != 52428800 {
+		t.Errorf("expected Stat.Anon 52428800, got %v", mem.Stat)
+	}
+	if mem.Stat.Pgmajfault == nil || *mem.Stat.Pgmajfault != 3 {
+		t.Errorf("expected Stat.Pgmajfault 3, got %v", mem.Stat.Pgmajfault)
+	}
+
+	if mem.Events == nil || mem.Events.High == nil || *mem.Events.High != 2 {
+		t.Errorf("expected Events.High 2, got %v", mem.Events)
+	}
+
+	if len(mem.NUMAStat) != 2 {
+		t.Fatalf("expected 2 memory.numa_stat categories, got %d", len(mem.NUMAStat))
+	}
+	if mem.NUMAStat[0].Category != "anon" || mem.NUMAStat[0].Nodes["0"] != 1048576 || mem.NUMAStat[0].Nodes["1"] != 2097152 {
+		t.Errorf("unexpected anon numa_stat: %+v", mem.NUMAStat[0])
+	}
+}
+
+func TestCgroupFSMemoryMaxSetLimit(t *testing.T) {
+	mountPoint := t.TempDir()
+	writeCgroupMemoryFixture(t, mountPoint, "/")
+	if err := ioutil.WriteFile(filepath.Join(mountPoint, "memory.max"), []byte("536870912\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := NewCgroupFS(mountPoint)
+	if err != nil {
+		t.Fatalf("failed to create CgroupFS: %v", err)
+	}
+
+	mem, err := fs.Memory("/")
+	if err != nil {
+		t.Fatalf("failed to parse cgroup memory: %v", err)
+	}
+	if mem.Max == nil || *mem.Max != 536870912 {
+		t.Errorf("expected Max 536870912, got %v", mem.Max)
+	}
+}
+
+func TestSelfCgroupPathFrom(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cgroup")
+	content := "0::/user.slice/user-1000.slice/session-1.scope\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := selfCgroupPathFrom(path)
+	if err != nil {
+		t.Fatalf("failed to parse cgroup path: %v", err)
+	}
+	if want := "/user.slice/user-1000.slice/session-1.scope"; got != want {
+		t.Errorf("expected cgroup path %q, got %q", want, got)
+	}
+}
+
+func TestDiscoverCgroupMountPointFrom(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mountinfo")
+	content := "25 30 0:22 / /sys rw,nosuid - sysfs sysfs rw\n" +
+		"26 25 0:23 / /sys/fs/cgroup rw,nosuid - cgroup2 cgroup2 rw\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := discoverCgroupMountPointFrom(path)
+	if err != nil {
+		t.Fatalf("failed to discover mount point: %v", err)
+	}
+	if want := "/sys/fs/cgroup"; got != want {
+		t.Errorf("expected mount point %q, got %q", want, got)
+	}
+}
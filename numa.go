@@ -0,0 +1,343 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package procfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs/internal/util"
+)
+
+const defaultSysMountPoint = "/sys"
+
+var nodeDirRE = regexp.MustCompile(`^node(\d+)$`)
+
+// NUMAMemInfo holds the counters parsed out of a single node's
+// /sys/devices/system/node/nodeN/meminfo file. The file carries a subset
+// of the fields found in /proc/meminfo, prefixed with "Node N".
+type NUMAMemInfo struct {
+	MemTotal          *uint64 `json:"mem_total"`
+	MemFree           *uint64 `json:"mem_free"`
+	MemUsed           *uint64 `json:"mem_used"`
+	SwapCached        *uint64 `json:"swap_cached"`
+	Active            *uint64 `json:"active"`
+	Inactive          *uint64 `json:"inactive"`
+	Dirty             *uint64 `json:"dirty"`
+	Writeback         *uint64 `json:"writeback"`
+	FilePages         *uint64 `json:"file_pages"`
+	Mapped            *uint64 `json:"mapped"`
+	AnonPages         *uint64 `json:"anon_pages"`
+	Shmem             *uint64 `json:"shmem"`
+	KernelStack       *uint64 `json:"kernel_stack"`
+	PageTables        *uint64 `json:"page_tables"`
+	SlabReclaimable   *uint64 `json:"slab_reclaimable"`
+	SlabUnreclaimable *uint64 `json:"slab_unreclaimable"`
+}
+
+func (info *NUMAMemInfo) parse(nodeField, valueField string) {
+	vp := util.NewValueParser(valueField)
+
+	switch nodeField {
+	case "MemTotal:":
+		info.MemTotal = vp.PUInt64()
+	case "MemFree:":
+		info.MemFree = vp.PUInt64()
+	case "MemUsed:":
+		info.MemUsed = vp.PUInt64()
+	case "SwapCached:":
+		info.SwapCached = vp.PUInt64()
+	case "Active:":
+		info.Active = vp.PUInt64()
+	case "Inactive:":
+		info.Inactive = vp.PUInt64()
+	case "Dirty:":
+		info.Dirty = vp.PUInt64()
+	case "Writeback:":
+		info.Writeback = vp.PUInt64()
+	case "FilePages:":
+		info.FilePages = vp.PUInt64()
+	case "Mapped:":
+		info.Mapped = vp.PUInt64()
+	case "AnonPages:":
+		info.AnonPages = vp.PUInt64()
+	case "Shmem:":
+		info.Shmem = vp.PUInt64()
+	case "KernelStack:":
+		info.KernelStack = vp.PUInt64()
+	case "PageTables:":
+		info.PageTables = vp.PUInt64()
+	case "SReclaimable:":
+		info.SlabReclaimable = vp.PUInt64()
+	case "SUnreclaim:":
+		info.SlabUnreclaimable = vp.PUInt64()
+	}
+}
+
+// NUMANumastat holds the counters parsed out of a single node's
+// /sys/devices/system/node/nodeN/numastat file.
+type NUMANumastat struct {
+	NumaHit       *uint64 `json:"numa_hit"`
+	NumaMiss      *uint64 `json:"numa_miss"`
+	NumaForeign   *uint64 `json:"numa_foreign"`
+	InterleaveHit *uint64 `json:"interleave_hit"`
+	LocalNode     *uint64 `json:"local_node"`
+	OtherNode     *uint64 `json:"other_node"`
+}
+
+func (stat *NUMANumastat) parse(key, valueField string) {
+	vp := util.NewValueParser(valueField)
+
+	switch key {
+	case "numa_hit":
+		stat.NumaHit = vp.PUInt64()
+	case "numa_miss":
+		stat.NumaMiss = vp.PUInt64()
+	case "numa_foreign":
+		stat.NumaForeign = vp.PUInt64()
+	case "interleave_hit":
+		stat.InterleaveHit = vp.PUInt64()
+	case "local_node":
+		stat.LocalNode = vp.PUInt64()
+	case "other_node":
+		stat.OtherNode = vp.PUInt64()
+	}
+}
+
+// NUMAHugePages holds the counters found under a single
+// /sys/devices/system/node/nodeN/hugepages/hugepages-SIZEkB directory.
+type NUMAHugePages struct {
+	Size    string
+	Total   *uint64 `json:"nr_hugepages"`
+	Free    *uint64 `json:"free_hugepages"`
+	Surplus *uint64 `json:"surplus_hugepages"`
+}
+
+// NUMANode holds the per-NUMA-node counters parsed out of
+// /sys/devices/system/node/nodeN, complementing the Nodes exposed by
+// Zoneinfo with the richer set of fields sysfs carries for each node.
+type NUMANode struct {
+	ID        int
+	MemInfo   NUMAMemInfo
+	Numastat  NUMANumastat
+	Vmstat    map[string]int64
+	HugePages []NUMAHugePages
+}
+
+// SysFS represents the pseudo-filesystem sys, which provides an
+// interface to kernel data structures.
+type SysFS struct {
+	sys string
+}
+
+// NewDefaultSysFS returns a new SysFS mounted under the default sysfs
+// mount point, /sys.
+func NewDefaultSysFS() (SysFS, error) {
+	return NewSysFS(defaultSysMountPoint)
+}
+
+// NewSysFS returns a new SysFS mounted under the given mount point.
+func NewSysFS(mountPoint string) (SysFS, error) {
+	info, err := os.Stat(mountPoint)
+	if err != nil {
+		return SysFS{}, fmt.Errorf("could not read %q: %s", mountPoint, err)
+	}
+	if !info.IsDir() {
+		return SysFS{}, fmt.Errorf("mount point %q is not a directory", mountPoint)
+	}
+	return SysFS{sys: mountPoint}, nil
+}
+
+func (fs SysFS) path(p ...string) string {
+	return filepath.Join(append([]string{fs.sys}, p...)...)
+}
+
+// NUMANodes parses /sys/devices/system/node/nodeN for every node the
+// kernel currently has online and returns one NUMANode per directory
+// found.
+func (fs SysFS) NUMANodes() ([]NUMANode, error) {
+	nodeDir := fs.path("devices", "system", "node")
+	dirs, err := ioutil.ReadDir(nodeDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", nodeDir, err)
+	}
+
+	var nodes []NUMANode
+	for _, d := range dirs {
+		m := nodeDirRE.FindStringSubmatch(d.Name())
+		if m == nil {
+			continue
+		}
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+
+		node, err := fs.parseNUMANode(filepath.Join(nodeDir, d.Name()), id)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (fs SysFS) parseNUMANode(dir string, id int) (NUMANode, error) {
+	node := NUMANode{ID: id}
+
+	if err := parseNUMAKeyValueFile(filepath.Join(dir, "meminfo"), func(line string) {
+		// Lines look like "Node 0 MemTotal:       16288264 kB", so the
+		// interesting key/value pair starts at the 3rd field.
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return
+		}
+		node.MemInfo.parse(fields[2], fields[3])
+	}); err != nil {
+		return NUMANode{}, err
+	}
+
+	if err := parseNUMAKeyValueFile(filepath.Join(dir, "numastat"), func(line string) {
+		// Lines look like "numa_hit 1743821": a field name followed by its
+		// value.
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return
+		}
+		node.Numastat.parse(fields[0], fields[1])
+	}); err != nil {
+		return NUMANode{}, err
+	}
+
+	vmstat, err := parseNUMAVmstat(filepath.Join(dir, "vmstat"))
+	if err != nil {
+		return NUMANode{}, err
+	}
+	node.Vmstat = vmstat
+
+	hugePages, err := parseNUMAHugePages(filepath.Join(dir, "hugepages"))
+	if err != nil {
+		return NUMANode{}, err
+	}
+	node.HugePages = hugePages
+
+	return node, nil
+}
+
+// parseNUMAKeyValueFile reads a whitespace separated "key value"-ish file
+// and hands each raw, trimmed line to fn exactly once. fn is responsible
+// for splitting the line into the fields it cares about.
+func parseNUMAKeyValueFile(path string, fn func(line string)) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fn(line)
+	}
+	return nil
+}
+
+func parseNUMAVmstat(path string) (map[string]int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	vmstat := map[string]int64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+		if v := util.NewValueParser(parts[1]).PInt64(); v != nil {
+			vmstat[parts[0]] = *v
+		}
+	}
+	return vmstat, nil
+}
+
+var hugePagesDirRE = regexp.MustCompile(`^hugepages-(\d+kB)$`)
+
+func parseNUMAHugePages(dir string) ([]NUMAHugePages, error) {
+	dirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %s: %s", dir, err)
+	}
+
+	var hugePages []NUMAHugePages
+	for _, d := range dirs {
+		m := hugePagesDirRE.FindStringSubmatch(d.Name())
+		if m == nil {
+			continue
+		}
+		hp := NUMAHugePages{Size: m[1]}
+		hp.Total, err = readNUMAHugePagesCounter(filepath.Join(dir, d.Name(), "nr_hugepages"))
+		if err != nil {
+			return nil, err
+		}
+		hp.Free, err = readNUMAHugePagesCounter(filepath.Join(dir, d.Name(), "free_hugepages"))
+		if err != nil {
+			return nil, err
+		}
+		hp.Surplus, err = readNUMAHugePagesCounter(filepath.Join(dir, d.Name(), "surplus_hugepages"))
+		if err != nil {
+			return nil, err
+		}
+		hugePages = append(hugePages, hp)
+	}
+	return hugePages, nil
+}
+
+func readNUMAHugePagesCounter(path string) (*uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return util.NewValueParser(strings.TrimSpace(string(data))).PUInt64(), nil
+}
+
+// MergeNUMA joins the richer per-NUMA-node sysfs counters in nodes onto
+// the matching NodeStats entries in z by node ID. Nodes on either side
+// with no matching counterpart are left untouched.
+func (z *Zoneinfo) MergeNUMA(nodes []NUMANode) {
+	byID := make(map[string]*NUMANode, len(nodes))
+	for i := range nodes {
+		byID[strconv.Itoa(nodes[i].ID)] = &nodes[i]
+	}
+
+	for _, ns := range z.Nodes {
+		n, ok := byID[ns.Node]
+		if !ok {
+			continue
+		}
+		ns.NUMAMemInfo = &n.MemInfo
+		ns.NUMANumastat = &n.Numastat
+		ns.NUMAHugePages = n.HugePages
+	}
+}
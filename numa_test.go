@@ -0,0 +1,167 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package procfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeNUMANodeFixture(t *testing.T, sysRoot string, id int) {
+	t.Helper()
+
+	nodeDir := filepath.Join(sysRoot, "devices", "system", "node", "node"+strconv.Itoa(id))
+	if err := os.MkdirAll(nodeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	meminfo := "Node " + strconv.Itoa(id) + " MemTotal:       16288264 kB\n" +
+		"Node " + strconv.Itoa(id) + " MemFree:         102400 kB\n"
+	if err := ioutil.WriteFile(filepath.Join(nodeDir, "meminfo"), []byte(meminfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	numastat := "numa_hit 1743821\n" +
+		"numa_miss 0\n" +
+		"numa_foreign 0\n" +
+		"interleave_hit 25152\n" +
+		"local_node 1743821\n" +
+		"other_node 0\n"
+	if err := ioutil.WriteFile(filepath.Join(nodeDir, "numastat"), []byte(numastat), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vmstat := "nr_free_pages 25600\nnr_zone_inactive_anon 57739\n"
+	if err := ioutil.WriteFile(filepath.Join(nodeDir, "vmstat"), []byte(vmstat), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hpDir := filepath.Join(nodeDir, "hugepages", "hugepages-2048kB")
+	if err := os.MkdirAll(hpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"nr_hugepages":      "64",
+		"free_hugepages":    "32",
+		"surplus_hugepages": "0",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(hpDir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSysFSNUMANodes(t *testing.T) {
+	sysRoot := t.TempDir()
+	writeNUMANodeFixture(t, sysRoot, 0)
+
+	fs, err := NewSysFS(sysRoot)
+	if err != nil {
+		t.Fatalf("failed to create SysFS: %v", err)
+	}
+
+	nodes, err := fs.NUMANodes()
+	if err != nil {
+		t.Fatalf("failed to parse NUMA nodes: %v", err)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(nodes))
+	}
+	node := nodes[0]
+
+	if node.ID != 0 {
+		t.Errorf("expected node ID 0, got %d", node.ID)
+	}
+	if node.MemInfo.MemTotal == nil || *node.MemInfo.MemTotal != 16288264 {
+		t.Errorf("expected MemTotal 16288264, got %v", node.MemInfo.MemTotal)
+	}
+	if node.MemInfo.MemFree == nil || *node.MemInfo.MemFree != 102400 {
+		t.Errorf("expected MemFree 102400, got %v", node.MemInfo.MemFree)
+	}
+
+	if node.Numastat.NumaHit == nil || *node.Numastat.NumaHit != 1743821 {
+		t.Errorf("expected NumaHit 1743821, got %v", node.Numastat.NumaHit)
+	}
+	if node.Numastat.InterleaveHit == nil || *node.Numastat.InterleaveHit != 25152 {
+		t.Errorf("expected InterleaveHit 25152, got %v", node.Numastat.InterleaveHit)
+	}
+	if node.Numastat.LocalNode == nil || *node.Numastat.LocalNode != 1743821 {
+		t.Errorf("expected LocalNode 1743821, got %v", node.Numastat.LocalNode)
+	}
+
+	if got, want := node.Vmstat["nr_free_pages"], int64(25600); got != want {
+		t.Errorf("expected vmstat nr_free_pages %d, got %d", want, got)
+	}
+
+	if len(node.HugePages) != 1 {
+		t.Fatalf("expected 1 hugepage size, got %d", len(node.HugePages))
+	}
+	hp := node.HugePages[0]
+	if hp.Size != "2048kB" {
+		t.Errorf("expected hugepage size 2048kB, got %s", hp.Size)
+	}
+	if hp.Total == nil || *hp.Total != 64 {
+		t.Errorf("expected 64 total hugepages, got %v", hp.Total)
+	}
+	if hp.Free == nil || *hp.Free != 32 {
+		t.Errorf("expected 32 free hugepages, got %v", hp.Free)
+	}
+}
+
+func TestZoneinfoMergeNUMA(t *testing.T) {
+	sysRoot := t.TempDir()
+	writeNUMANodeFixture(t, sysRoot, 0)
+
+	fs, err := NewSysFS(sysRoot)
+	if err != nil {
+		t.Fatalf("failed to create SysFS: %v", err)
+	}
+	nodes, err := fs.NUMANodes()
+	if err != nil {
+		t.Fatalf("failed to parse NUMA nodes: %v", err)
+	}
+
+	zi := Zoneinfo{
+		Nodes: []*NodeStats{
+			{Node: "0", NrInactiveAnon: newPInt64(57739)},
+			{Node: "1"},
+		},
+	}
+	zi.MergeNUMA(nodes)
+
+	if zi.Nodes[0].NUMANumastat == nil || zi.Nodes[0].NUMANumastat.NumaHit == nil || *zi.Nodes[0].NUMANumastat.NumaHit != 1743821 {
+		t.Fatalf("expected node 0 to carry merged numastat, got %+v", zi.Nodes[0].NUMANumastat)
+	}
+	if zi.Nodes[0].NUMAMemInfo == nil || zi.Nodes[0].NUMAMemInfo.MemTotal == nil {
+		t.Fatalf("expected node 0 to carry merged meminfo, got %+v", zi.Nodes[0].NUMAMemInfo)
+	}
+	if len(zi.Nodes[0].NUMAHugePages) != 1 {
+		t.Fatalf("expected node 0 to carry merged hugepages, got %+v", zi.Nodes[0].NUMAHugePages)
+	}
+
+	if zi.Nodes[1].NUMANumastat != nil {
+		t.Errorf("expected node 1 (no matching sysfs node) to be left untouched, got %+v", zi.Nodes[1].NUMANumastat)
+	}
+
+	if diff := cmp.Diff(int64(57739), *zi.Nodes[0].NrInactiveAnon); diff != "" {
+		t.Errorf("MergeNUMA must not touch fields it doesn't own (-want +got):\n%s", diff)
+	}
+}
@@ -16,13 +16,12 @@
 package procfs
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
-	"io/ioutil"
+	"os"
 	"regexp"
-	"strings"
-
-	"github.com/prometheus/procfs/internal/util"
+	"strconv"
 )
 
 // Tag values describe a source field from /proc/zoneinfo
@@ -57,6 +56,7 @@ type ZoneStats struct {
 	NumaLocal        *int64   `json:"numa_local"`
 	NumaOther        *int64   `json:"numa_other"`
 	Protection       []*int64 `json:"protection"`
+	WatermarkBoost   *int64   `json:"watermark_boost"`
 }
 
 type NodeStats struct {
@@ -88,6 +88,14 @@ type NodeStats struct {
 	NrVmscanImmediateReclaim   *int64 `json:"nr_vmscan_immediate_reclaim"`
 	NrDirtied                  *int64 `json:"nr_dirtied"`
 	NrWritten                  *int64 `json:"nr_written"`
+
+	// NUMAMemInfo, NUMANumastat and NUMAHugePages are not populated by
+	// Zoneinfo itself; they are filled in by Zoneinfo.MergeNUMA from the
+	// richer per-node counters sysfs exposes under
+	// /sys/devices/system/node/nodeN.
+	NUMAMemInfo   *NUMAMemInfo
+	NUMANumastat  *NUMANumastat
+	NUMAHugePages []NUMAHugePages
 }
 
 // Zoneinfo holds info parsed from /proc/zoneinfo.
@@ -96,221 +104,359 @@ type Zoneinfo struct {
 	Zones []*ZoneStats
 }
 
-const (
-	ProcessingNodeStats = 1
-	ProcessingZoneStats = 2
-)
-
-var nodeZoneRE = regexp.MustCompile(`(\d+), zone\s+(\w+)`)
-
 // Zoneinfo parses an zoneinfo-file (/proc/zoneinfo) and returns a slice of
 // structs containing the relevant info.  More information available here:
 // https://www.kernel.org/doc/Documentation/sysctl/vm.txt
+//
+// Zoneinfo allocates a fresh Zoneinfo and ZoneinfoBuf on every call; callers
+// scraping /proc/zoneinfo repeatedly should keep their own Zoneinfo and
+// ZoneinfoBuf around and call FS.ZoneinfoInto instead.
 func (fs FS) Zoneinfo() (Zoneinfo, error) {
-	data, err := ioutil.ReadFile(fs.proc.Path("zoneinfo"))
+	var (
+		zoneinfo Zoneinfo
+		buf      ZoneinfoBuf
+	)
+	if err := fs.ZoneinfoInto(&zoneinfo, &buf); err != nil {
+		return Zoneinfo{}, err
+	}
+	return zoneinfo, nil
+}
+
+// ZoneinfoBuf is caller-owned scratch space for FS.ZoneinfoInto. Reusing the
+// same ZoneinfoBuf (and the same destination Zoneinfo) across repeated calls
+// avoids re-allocating the raw file buffer, the per-line scan buffer, the
+// NodeStats/ZoneStats backing arrays, and the individual *int64 counters in
+// them, as long as the set of fields /proc/zoneinfo reports doesn't change
+// between calls. A field /proc/zoneinfo stops reporting keeps showing its
+// last known value in a reused dst rather than reverting to nil; this
+// doesn't happen in practice since the kernel doesn't drop zoneinfo fields
+// at runtime.
+type ZoneinfoBuf struct {
+	raw   []byte
+	line  []byte
+	zones []ZoneStats
+	nodes []NodeStats
+}
+
+// ZoneinfoInto parses /proc/zoneinfo into dst, reusing dst's Nodes and Zones
+// slices, the NodeStats/ZoneStats they point to, and buf's scratch buffers
+// wherever their capacity allows it, instead of allocating fresh ones on
+// every call the way Zoneinfo does.
+func (fs FS) ZoneinfoInto(dst *Zoneinfo, buf *ZoneinfoBuf) error {
+	path := fs.proc.Path("zoneinfo")
+	f, err := os.Open(path)
 	if err != nil {
-		return Zoneinfo{}, fmt.Errorf("error reading zoneinfo %s: %s", fs.proc.Path("zoneinfo"), err)
+		return fmt.Errorf("error reading zoneinfo %s: %s", path, err)
+	}
+	defer f.Close()
+
+	raw := bytes.NewBuffer(buf.raw[:0])
+	if _, err := raw.ReadFrom(f); err != nil {
+		return fmt.Errorf("error reading zoneinfo %s: %s", path, err)
+	}
+	buf.raw = raw.Bytes()
+
+	if err := parseZoneinfoInto(buf.raw, dst, buf); err != nil {
+		return fmt.Errorf("error parsing zoneinfo %s: %s", path, err)
+	}
+	return nil
+}
+
+var nodeZoneLineRE = regexp.MustCompile(`^Node\s+(\d+), zone\s+(\S+)`)
+
+// parseZoneinfoInto walks raw line by line with a bufio.Scanner instead of
+// splitting the whole file into per-zone blocks and per-line slices up
+// front, appending onto dst.Nodes/dst.Zones in place. The ZoneStats/NodeStats
+// themselves come out of buf.zones/buf.nodes, a pair of pools that grow on
+// demand but are otherwise reused call over call, so a zone or node that
+// existed on the previous call keeps its backing struct (and the *int64
+// counters already allocated in it) instead of getting a fresh one.
+func parseZoneinfoInto(raw []byte, dst *Zoneinfo, buf *ZoneinfoBuf) error {
+	dst.Nodes = dst.Nodes[:0]
+	dst.Zones = dst.Zones[:0]
+	buf.zones = buf.zones[:0]
+	buf.nodes = buf.nodes[:0]
+
+	if cap(buf.line) == 0 {
+		buf.line = make([]byte, 0, 4096)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(buf.line, bufio.MaxScanTokenSize)
+
+	var (
+		currentNode         string
+		zonestats           *ZoneStats
+		nodestats           *NodeStats
+		processingNodeStats bool
+	)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if m := nodeZoneLineRE.FindSubmatch(line); m != nil {
+			currentNode = string(m[1])
+			zonestats = nextZoneStats(buf)
+			zonestats.Node = currentNode
+			zonestats.Zone = string(m[2])
+			dst.Zones = append(dst.Zones, zonestats)
+			nodestats = nil
+			processingNodeStats = false
+			continue
+		}
+		if zonestats == nil {
+			// Lines before the first "Node N, zone X" header are not part
+			// of any zone; this must not happen but skip them just in case.
+			continue
+		}
+
+		if bytes.HasPrefix(line, []byte("per-node stats")) {
+			processingNodeStats = true
+			nodestats = nextNodeStats(buf)
+			nodestats.Node = currentNode
+			dst.Nodes = append(dst.Nodes, nodestats)
+			continue
+		} else if bytes.HasPrefix(line, []byte("pages free")) {
+			processingNodeStats = false
+		}
+
+		if processingNodeStats {
+			nodestats.parseBytes(line)
+		} else {
+			zonestats.parseBytes(line)
+		}
+	}
+	// Growing a pool by append moves it to a new array, so point dst at the
+	// pools' final arrays; the next call then hands out these same structs.
+	for i := range dst.Zones {
+		dst.Zones[i] = &buf.zones[i]
+	}
+	for i := range dst.Nodes {
+		dst.Nodes[i] = &buf.nodes[i]
+	}
+	return scanner.Err()
+}
+
+// nextZoneStats returns the next ZoneStats out of buf.zones, growing the
+// pool only if every element already taken this call came from a previous
+// one. Fields not explicitly set by the caller keep whatever they held on
+// the pool slot's last use; parseBytes only ever assigns through
+// parseInt64BytesInto, which reuses the existing *int64 instead of
+// discarding it.
+func nextZoneStats(buf *ZoneinfoBuf) *ZoneStats {
+	if len(buf.zones) < cap(buf.zones) {
+		buf.zones = buf.zones[:len(buf.zones)+1]
+	} else {
+		buf.zones = append(buf.zones, ZoneStats{})
 	}
-	zoneinfo, err := parseZoneinfo(data)
+	return &buf.zones[len(buf.zones)-1]
+}
+
+// nextNodeStats is nextZoneStats for buf.nodes.
+func nextNodeStats(buf *ZoneinfoBuf) *NodeStats {
+	if len(buf.nodes) < cap(buf.nodes) {
+		buf.nodes = buf.nodes[:len(buf.nodes)+1]
+	} else {
+		buf.nodes = append(buf.nodes, NodeStats{})
+	}
+	return &buf.nodes[len(buf.nodes)-1]
+}
+
+// fieldValueBytes splits a "key value"-shaped zoneinfo line into its key
+// and value, using bytes.IndexByte to avoid allocating a []byte slice per
+// field the way bytes.Fields/strings.Fields would.
+func fieldValueBytes(line []byte) (key, value []byte, ok bool) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, nil, false
+	}
+	key = line[:i]
+	value = bytes.TrimSpace(line[i+1:])
+	if len(value) == 0 {
+		return nil, nil, false
+	}
+	// Lines can carry more than one run of whitespace between key and
+	// value (e.g. "min      33"); fold it down to the first token.
+	if j := bytes.IndexByte(value, ' '); j >= 0 {
+		value = value[:j]
+	}
+	return key, value, true
+}
+
+// parseInt64BytesInto parses value into dst, reusing dst's existing
+// allocation when it already points at one instead of allocating a new
+// *int64 for every field of every zone on every call. It returns nil,
+// leaving dst untouched, if value doesn't parse.
+func parseInt64BytesInto(dst *int64, value []byte) *int64 {
+	v, err := strconv.ParseInt(string(value), 10, 64)
 	if err != nil {
-		return Zoneinfo{}, fmt.Errorf("error parsing zoneinfo %s: %s", fs.proc.Path("zoneinfo"), err)
+		return nil
 	}
-	return zoneinfo, nil
+	if dst == nil {
+		dst = new(int64)
+	}
+	*dst = v
+	return dst
 }
 
-func (stats *NodeStats) parse(line string) {
-	parts := strings.Fields(line)
-	if len(parts) < 2 {
+func (stats *NodeStats) parseBytes(line []byte) {
+	key, value, ok := fieldValueBytes(line)
+	if !ok {
 		return
 	}
-	vp := util.NewValueParser(parts[1])
 
-	switch parts[0] {
+	switch string(key) {
 	case "nr_inactive_anon":
-		stats.NrInactiveAnon = vp.PInt64()
+		stats.NrInactiveAnon = parseInt64BytesInto(stats.NrInactiveAnon, value)
 	case "nr_active_anon":
-		stats.NrActiveAnon = vp.PInt64()
+		stats.NrActiveAnon = parseInt64BytesInto(stats.NrActiveAnon, value)
 	case "nr_inactive_file":
-		stats.NrInactiveFile = vp.PInt64()
+		stats.NrInactiveFile = parseInt64BytesInto(stats.NrInactiveFile, value)
 	case "nr_active_file":
-		stats.NrActiveFile = vp.PInt64()
+		stats.NrActiveFile = parseInt64BytesInto(stats.NrActiveFile, value)
 	case "nr_unevictable":
-		stats.NrUnevictable = vp.PInt64()
+		stats.NrUnevictable = parseInt64BytesInto(stats.NrUnevictable, value)
 	case "nr_slab_reclaimable":
-		stats.NrSlabReclaimable = vp.PInt64()
+		stats.NrSlabReclaimable = parseInt64BytesInto(stats.NrSlabReclaimable, value)
 	case "nr_slab_unreclaimable":
-		stats.NrSlabUnreclaimable = vp.PInt64()
+		stats.NrSlabUnreclaimable = parseInt64BytesInto(stats.NrSlabUnreclaimable, value)
 	case "nr_isolated_anon":
-		stats.NrIsolatedAnon = vp.PInt64()
+		stats.NrIsolatedAnon = parseInt64BytesInto(stats.NrIsolatedAnon, value)
 	case "nr_isolated_file":
-		stats.NrIsolatedFile = vp.PInt64()
+		stats.NrIsolatedFile = parseInt64BytesInto(stats.NrIsolatedFile, value)
 	case "workingset_refault":
-		stats.NrWorkingsetRefault = vp.PInt64()
+		stats.NrWorkingsetRefault = parseInt64BytesInto(stats.NrWorkingsetRefault, value)
 	case "workingset_activate":
-		stats.NrWorkingsetActivate = vp.PInt64()
+		stats.NrWorkingsetActivate = parseInt64BytesInto(stats.NrWorkingsetActivate, value)
 	case "workingset_nodereclaim":
-		stats.NrWorkingsetNodereclaim = vp.PInt64()
+		stats.NrWorkingsetNodereclaim = parseInt64BytesInto(stats.NrWorkingsetNodereclaim, value)
 	case "nr_anon_pages":
-		stats.NrAnonPages = vp.PInt64()
+		stats.NrAnonPages = parseInt64BytesInto(stats.NrAnonPages, value)
 	case "nr_mapped":
-		stats.NrMapped = vp.PInt64()
+		stats.NrMapped = parseInt64BytesInto(stats.NrMapped, value)
 	case "nr_file_pages":
-		stats.NrFilePages = vp.PInt64()
+		stats.NrFilePages = parseInt64BytesInto(stats.NrFilePages, value)
 	case "nr_dirty":
-		stats.NrDirty = vp.PInt64()
+		stats.NrDirty = parseInt64BytesInto(stats.NrDirty, value)
 	case "nr_writeback":
-		stats.NrWriteback = vp.PInt64()
+		stats.NrWriteback = parseInt64BytesInto(stats.NrWriteback, value)
 	case "nr_writeback_temp":
-		stats.NrWritebackTemp = vp.PInt64()
+		stats.NrWritebackTemp = parseInt64BytesInto(stats.NrWritebackTemp, value)
 	case "nr_shmem":
-		stats.NrShmem = vp.PInt64()
+		stats.NrShmem = parseInt64BytesInto(stats.NrShmem, value)
 	case "nr_shmem_hugepages":
-		stats.NrShmemHugepages = vp.PInt64()
+		stats.NrShmemHugepages = parseInt64BytesInto(stats.NrShmemHugepages, value)
 	case "nr_shmem_pmdmapped":
-		stats.NrShmemPmdMapped = vp.PInt64()
+		stats.NrShmemPmdMapped = parseInt64BytesInto(stats.NrShmemPmdMapped, value)
 	case "nr_anon_transparent_hugepages":
-		stats.NrAnonTransparentHugepages = vp.PInt64()
+		stats.NrAnonTransparentHugepages = parseInt64BytesInto(stats.NrAnonTransparentHugepages, value)
 	case "nr_unstable":
-		stats.NrUnstable = vp.PInt64()
+		stats.NrUnstable = parseInt64BytesInto(stats.NrUnstable, value)
 	case "nr_vmscan_write":
-		stats.NrVmscanWrite = vp.PInt64()
+		stats.NrVmscanWrite = parseInt64BytesInto(stats.NrVmscanWrite, value)
 	case "nr_vmscan_immediate_reclaim":
-		stats.NrVmscanImmediateReclaim = vp.PInt64()
+		stats.NrVmscanImmediateReclaim = parseInt64BytesInto(stats.NrVmscanImmediateReclaim, value)
 	case "nr_dirtied":
-		stats.NrDirtied = vp.PInt64()
+		stats.NrDirtied = parseInt64BytesInto(stats.NrDirtied, value)
 	case "nr_written":
-		stats.NrWritten = vp.PInt64()
+		stats.NrWritten = parseInt64BytesInto(stats.NrWritten, value)
 	}
 }
 
-func (stats *ZoneStats) parse(line string) {
-	parts := strings.Fields(line)
-	if len(parts) < 2 {
+func (stats *ZoneStats) parseBytes(line []byte) {
+	if bytes.HasPrefix(line, []byte("pages free")) {
+		if v := parseInt64BytesInto(stats.Free, bytes.TrimSpace(line[len("pages free"):])); v != nil {
+			stats.Free = v
+		}
 		return
 	}
 
-	if (parts[0] == "pages") && (parts[1] == "free") {
-		vp := util.NewValueParser(parts[2])
-		stats.Free = vp.PInt64()
+	if bytes.HasPrefix(line, []byte("protection:")) {
+		rest := bytes.TrimSpace(line[len("protection:"):])
+		rest = bytes.TrimPrefix(rest, []byte("("))
+		rest = bytes.TrimSuffix(rest, []byte(")"))
+		parts := bytes.Split(rest, []byte(","))
+		protection := stats.Protection[:0]
+		for _, p := range parts {
+			var dst *int64
+			if len(protection) < len(stats.Protection) {
+				dst = stats.Protection[len(protection)]
+			}
+			v := parseInt64BytesInto(dst, bytes.TrimSpace(p))
+			if v == nil {
+				continue
+			}
+			protection = append(protection, v)
+		}
+		stats.Protection = protection
 		return
 	}
 
-	vp := util.NewValueParser(parts[1])
+	key, value, ok := fieldValueBytes(line)
+	if !ok {
+		return
+	}
 
-	switch parts[0] {
+	switch string(key) {
 	case "min":
-		stats.Min = vp.PInt64()
+		stats.Min = parseInt64BytesInto(stats.Min, value)
 	case "low":
-		stats.Low = vp.PInt64()
+		stats.Low = parseInt64BytesInto(stats.Low, value)
 	case "high":
-		stats.High = vp.PInt64()
+		stats.High = parseInt64BytesInto(stats.High, value)
 	case "scanned":
-		stats.Scanned = vp.PInt64()
+		stats.Scanned = parseInt64BytesInto(stats.Scanned, value)
 	case "spanned":
-		stats.Spanned = vp.PInt64()
+		stats.Spanned = parseInt64BytesInto(stats.Spanned, value)
 	case "present":
-		stats.Present = vp.PInt64()
+		stats.Present = parseInt64BytesInto(stats.Present, value)
 	case "managed":
-		stats.Managed = vp.PInt64()
-	case "nr_zone_inactive_anon":
-		stats.NrInactiveAnon = vp.PInt64()
-	case "nr_zone_active_anon":
-		stats.NrActiveAnon = vp.PInt64()
-	case "nr_zone_inactive_file":
-		stats.NrInactiveFile = vp.PInt64()
-	case "nr_zone_active_file":
-		stats.NrActiveFile = vp.PInt64()
-	case "nr_zone_unevictable":
-		stats.NrUnevictable = vp.PInt64()
+		stats.Managed = parseInt64BytesInto(stats.Managed, value)
+	// Linux 4.8 moved several per-zone counters to a node-level "per-node
+	// stats" section and renamed the zone-level survivors with an
+	// "nr_zone_" prefix. Pre-4.8 kernels have no per-node stats section at
+	// all and still report these same counters at the zone level under
+	// their original, unprefixed names; accept both so ZoneStats.NrXxx is
+	// populated either way.
+	case "nr_zone_inactive_anon", "nr_inactive_anon":
+		stats.NrInactiveAnon = parseInt64BytesInto(stats.NrInactiveAnon, value)
+	case "nr_zone_active_anon", "nr_active_anon":
+		stats.NrActiveAnon = parseInt64BytesInto(stats.NrActiveAnon, value)
+	case "nr_zone_inactive_file", "nr_inactive_file":
+		stats.NrInactiveFile = parseInt64BytesInto(stats.NrInactiveFile, value)
+	case "nr_zone_active_file", "nr_active_file":
+		stats.NrActiveFile = parseInt64BytesInto(stats.NrActiveFile, value)
+	case "nr_zone_unevictable", "nr_unevictable":
+		stats.NrUnevictable = parseInt64BytesInto(stats.NrUnevictable, value)
 	case "nr_zone_write_pending":
-		stats.NrWritePending = vp.PInt64()
+		stats.NrWritePending = parseInt64BytesInto(stats.NrWritePending, value)
 	case "nr_mlock":
-		stats.NrMlock = vp.PInt64()
+		stats.NrMlock = parseInt64BytesInto(stats.NrMlock, value)
 	case "nr_page_table_pages":
-		stats.NrPageTablePages = vp.PInt64()
+		stats.NrPageTablePages = parseInt64BytesInto(stats.NrPageTablePages, value)
 	case "nr_kernel_stack":
-		stats.NrKernelStack = vp.PInt64()
+		stats.NrKernelStack = parseInt64BytesInto(stats.NrKernelStack, value)
 	case "nr_bounce":
-		stats.NrBounce = vp.PInt64()
+		stats.NrBounce = parseInt64BytesInto(stats.NrBounce, value)
 	case "nr_zspages":
-		stats.NrZsPages = vp.PInt64()
+		stats.NrZsPages = parseInt64BytesInto(stats.NrZsPages, value)
 	case "nr_free_cma":
-		stats.NrFreeCma = vp.PInt64()
+		stats.NrFreeCma = parseInt64BytesInto(stats.NrFreeCma, value)
 	case "numa_hit":
-		stats.NumaHit = vp.PInt64()
+		stats.NumaHit = parseInt64BytesInto(stats.NumaHit, value)
 	case "numa_miss":
-		stats.NumaMiss = vp.PInt64()
+		stats.NumaMiss = parseInt64BytesInto(stats.NumaMiss, value)
 	case "numa_foreign":
-		stats.NumaForeign = vp.PInt64()
+		stats.NumaForeign = parseInt64BytesInto(stats.NumaForeign, value)
 	case "numa_interleave":
-		stats.NumaInterleave = vp.PInt64()
+		stats.NumaInterleave = parseInt64BytesInto(stats.NumaInterleave, value)
 	case "numa_local":
-		stats.NumaLocal = vp.PInt64()
+		stats.NumaLocal = parseInt64BytesInto(stats.NumaLocal, value)
 	case "numa_other":
-		stats.NumaOther = vp.PInt64()
-	case "protection:":
-		protectionParts := strings.Split(line, ":")
-		protectionValues := strings.Replace(protectionParts[1], "(", "", 1)
-		protectionValues = strings.Replace(protectionValues, ")", "", 1)
-		protectionValues = strings.TrimSpace(protectionValues)
-		protectionStringMap := strings.Split(protectionValues, ", ")
-		val, err := util.ParsePInt64s(protectionStringMap)
-		if err == nil {
-			stats.Protection = val
-		}
+		stats.NumaOther = parseInt64BytesInto(stats.NumaOther, value)
+	case "watermark_boost":
+		stats.WatermarkBoost = parseInt64BytesInto(stats.WatermarkBoost, value)
 	}
 }
-
-func parseZoneinfo(zoneinfoData []byte) (Zoneinfo, error) {
-	zoneinfo := Zoneinfo{}
-
-	zoneinfoBlocks := bytes.Split(zoneinfoData, []byte("\nNode"))
-	for _, block := range zoneinfoBlocks {
-		var currentNode, currentZone string
-		var nodestats *NodeStats
-		var zonestats *ZoneStats
-
-		data := strings.Split(string(block), "\n")
-
-		// This must not happen but still we have to check the size of "data" before slicing it
-		if len(data) < 2 {
-			continue
-		}
-		header, lines := data[0], data[1:]
-
-		// First line must be "(Node )?\d+, zone\s+\w+" since we split zoneinfoData by "\nNode"
-		nodeZone := nodeZoneRE.FindStringSubmatch(header)
-		if nodeZone == nil {
-			continue
-		}
-		currentNode = nodeZone[1]
-		currentZone = nodeZone[2]
-		zonestats = &ZoneStats{Node: currentNode, Zone: currentZone}
-
-		state := ProcessingZoneStats
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-
-			if strings.HasPrefix(line, "per-node stats") {
-				state = ProcessingNodeStats
-				nodestats = &NodeStats{Node: currentNode}
-				continue
-			} else if strings.HasPrefix(line, "pages free") {
-				state = ProcessingZoneStats
-			}
-
-			switch state {
-			case ProcessingNodeStats:
-				nodestats.parse(line)
-			case ProcessingZoneStats:
-				zonestats.parse(line)
-			}
-		}
-
-		if nodestats != nil {
-			zoneinfo.Nodes = append(zoneinfo.Nodes, nodestats)
-		}
-		zoneinfo.Zones = append(zoneinfo.Zones, zonestats)
-	}
-	return zoneinfo, nil
-}
@@ -0,0 +1,153 @@
+// Copyright 2019 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package procfs
+
+// ZonePressure holds reclaim-pressure signals derived from a single
+// zone's ZoneStats, without callers having to reimplement the
+// min/low/high watermark arithmetic themselves.
+type ZonePressure struct {
+	Node string
+	Zone string
+
+	// DistanceToMin, DistanceToLow and DistanceToHigh are Free minus the
+	// respective watermark, in pages; Low and High include WatermarkBoost.
+	// Negative means the zone is already below that watermark. Always set:
+	// Pressure skips zones missing Free, Min, Low, High or Managed.
+	DistanceToMin  *int64
+	DistanceToLow  *int64
+	DistanceToHigh *int64
+
+	// DistanceToMinRatio, DistanceToLowRatio and DistanceToHighRatio are
+	// the same distances expressed as a fraction of Managed pages.
+	DistanceToMinRatio  *float64
+	DistanceToLowRatio  *float64
+	DistanceToHighRatio *float64
+
+	// BoostActive is true when the kernel has raised the low/high
+	// watermarks above their configured values (WatermarkBoost > 0).
+	BoostActive bool
+
+	// MemoryPressure is 0 when Free is at or above the (boosted) low
+	// watermark, 1 when it is at or below the min watermark, computed as
+	// (low+boost-free) / (low+boost-min) clamped to [0, 1]. Nil if
+	// low+boost equals min.
+	MemoryPressure *float64
+}
+
+// pressure computes the ZonePressure for a single zone. It returns nil if
+// the zone doesn't carry enough of the watermark fields to say anything
+// useful (e.g. the unpopulated "Movable"/"Device" zones zoneinfo often
+// lists with all-zero watermarks).
+func (z *ZoneStats) pressure() *ZonePressure {
+	if z.Free == nil || z.Min == nil || z.Low == nil || z.High == nil || z.Managed == nil {
+		return nil
+	}
+	if *z.Managed == 0 {
+		return nil
+	}
+
+	var boost int64
+	if z.WatermarkBoost != nil {
+		boost = *z.WatermarkBoost
+	}
+
+	p := &ZonePressure{
+		Node:        z.Node,
+		Zone:        z.Zone,
+		BoostActive: boost > 0,
+	}
+
+	managed := float64(*z.Managed)
+
+	distMin := *z.Free - *z.Min
+	p.DistanceToMin = &distMin
+	distLow := *z.Free - (*z.Low + boost)
+	p.DistanceToLow = &distLow
+	distHigh := *z.Free - (*z.High + boost)
+	p.DistanceToHigh = &distHigh
+
+	if managed > 0 {
+		ratioMin := float64(distMin) / managed
+		p.DistanceToMinRatio = &ratioMin
+		ratioLow := float64(distLow) / managed
+		p.DistanceToLowRatio = &ratioLow
+		ratioHigh := float64(distHigh) / managed
+		p.DistanceToHighRatio = &ratioHigh
+	}
+
+	denom := float64(*z.Low+boost) - float64(*z.Min)
+	if denom != 0 {
+		score := (float64(*z.Low+boost) - float64(*z.Free)) / denom
+		if score < 0 {
+			score = 0
+		} else if score > 1 {
+			score = 1
+		}
+		p.MemoryPressure = &score
+	}
+
+	return p
+}
+
+// NodePressure aggregates the ZonePressure of every zone belonging to a
+// single NUMA node.
+type NodePressure struct {
+	Node string
+
+	// MemoryPressure is the highest MemoryPressure score across the
+	// node's zones, i.e. how close to reclaim its most pressured zone is.
+	MemoryPressure *float64
+}
+
+// ZoneinfoPressure holds the derived reclaim-pressure view over a
+// Zoneinfo, built by Zoneinfo.Pressure.
+type ZoneinfoPressure struct {
+	Zones []ZonePressure
+	Nodes []NodePressure
+}
+
+// Pressure derives a portable "how close is this zone to reclaim?" view
+// from the watermark fields Zoneinfo already parsed out of
+// /proc/zoneinfo, so callers don't have to reimplement the arithmetic
+// against Free, Min, Low, High and WatermarkBoost themselves.
+func (z Zoneinfo) Pressure() ZoneinfoPressure {
+	var out ZoneinfoPressure
+
+	nodeIdx := make(map[string]int)
+	for _, zone := range z.Zones {
+		zp := zone.pressure()
+		if zp == nil {
+			continue
+		}
+		out.Zones = append(out.Zones, *zp)
+
+		i, ok := nodeIdx[zone.Node]
+		if !ok {
+			i = len(out.Nodes)
+			nodeIdx[zone.Node] = i
+			out.Nodes = append(out.Nodes, NodePressure{Node: zone.Node})
+		}
+		if zp.MemoryPressure == nil {
+			continue
+		}
+		if out.Nodes[i].MemoryPressure == nil || *zp.MemoryPressure > *out.Nodes[i].MemoryPressure {
+			score := *zp.MemoryPressure
+			out.Nodes[i].MemoryPressure = &score
+		}
+	}
+
+	return out
+}
@@ -16,6 +16,10 @@
 package procfs
 
 import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -77,3 +81,306 @@ func TestZoneinfo(t *testing.T) {
 		t.Fatalf("unexpected zoneinfo entry (-want +got):\n%s", diff)
 	}
 }
+
+func TestZoneinfoInto(t *testing.T) {
+	fs := getProcFixtures(t)
+
+	want, err := fs.Zoneinfo()
+	if err != nil {
+		t.Fatalf("failed to parse zoneinfo: %v", err)
+	}
+
+	var (
+		got Zoneinfo
+		buf ZoneinfoBuf
+	)
+	// Parse twice into the same dst/buf to exercise the reuse path.
+	for i := 0; i < 2; i++ {
+		if err := fs.ZoneinfoInto(&got, &buf); err != nil {
+			t.Fatalf("failed to parse zoneinfo: %v", err)
+		}
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected zoneinfo entry (-want +got):\n%s", diff)
+	}
+}
+
+// TestZoneinfoIntoReusesAllocations guards the actual point of
+// FS.ZoneinfoInto: that repeated calls with a stable zone/node set reuse
+// the same ZoneStats/NodeStats/*int64 storage instead of allocating fresh
+// ones. TestZoneinfoInto only compares values, which a correct-but
+// always-reallocating implementation would also pass.
+func TestZoneinfoIntoReusesAllocations(t *testing.T) {
+	fs := getProcFixtures(t)
+
+	var (
+		got Zoneinfo
+		buf ZoneinfoBuf
+	)
+	if err := fs.ZoneinfoInto(&got, &buf); err != nil {
+		t.Fatalf("failed to parse zoneinfo: %v", err)
+	}
+	zones := append([]*ZoneStats(nil), got.Zones...)
+	nodes := append([]*NodeStats(nil), got.Nodes...)
+	var freePtrs, minPtrs []*int64
+	for _, z := range got.Zones {
+		freePtrs = append(freePtrs, z.Free)
+		minPtrs = append(minPtrs, z.Min)
+	}
+
+	if err := fs.ZoneinfoInto(&got, &buf); err != nil {
+		t.Fatalf("failed to parse zoneinfo: %v", err)
+	}
+
+	if len(got.Zones) != len(zones) || len(got.Nodes) != len(nodes) {
+		t.Fatalf("zone/node count changed between calls: %d/%d -> %d/%d", len(zones), len(nodes), len(got.Zones), len(got.Nodes))
+	}
+	for i := range zones {
+		if got.Zones[i] != zones[i] {
+			t.Errorf("zone %d: expected the same *ZoneStats to be reused, got a new allocation", i)
+		}
+		if got.Zones[i].Free != freePtrs[i] {
+			t.Errorf("zone %d: expected Free's *int64 to be reused, got a new allocation", i)
+		}
+		if got.Zones[i].Min != minPtrs[i] {
+			t.Errorf("zone %d: expected Min's *int64 to be reused, got a new allocation", i)
+		}
+	}
+	for i := range nodes {
+		if got.Nodes[i] != nodes[i] {
+			t.Errorf("node %d: expected the same *NodeStats to be reused, got a new allocation", i)
+		}
+	}
+
+	// Per-field strconv.ParseInt still needs a string conversion, and
+	// os.Open/bufio.NewScanner allocate their own state every call, so this
+	// never reaches literally 0; the steady-state figure is only logged for
+	// inspection, not asserted against a bound.
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := fs.ZoneinfoInto(&got, &buf); err != nil {
+			t.Fatalf("failed to parse zoneinfo: %v", err)
+		}
+	})
+	t.Logf("steady-state ZoneinfoInto: %.1f allocs/op", allocs)
+}
+
+// TestZoneinfoLegacyZoneFields exercises pre-4.8-kernel-shaped zoneinfo
+// output, where nr_(in)active_(anon|file)/nr_unevictable are reported
+// directly in the zone block under their unprefixed names and there is no
+// "per-node stats" section at all.
+func TestZoneinfoLegacyZoneFields(t *testing.T) {
+	raw := []byte("Node 0, zone   DMA\n" +
+		"  pages free     3952\n" +
+		"        min      33\n" +
+		"        low      41\n" +
+		"        high     49\n" +
+		"        spanned  4095\n" +
+		"        present  3975\n" +
+		"        managed  3956\n" +
+		"        nr_inactive_anon 1\n" +
+		"        nr_active_anon 2\n" +
+		"        nr_inactive_file 3\n" +
+		"        nr_active_file 4\n" +
+		"        nr_unevictable 5\n" +
+		"        numa_hit 1\n")
+
+	var (
+		dst Zoneinfo
+		buf ZoneinfoBuf
+	)
+	if err := parseZoneinfoInto(raw, &dst, &buf); err != nil {
+		t.Fatalf("failed to parse zoneinfo: %v", err)
+	}
+
+	if len(dst.Zones) != 1 {
+		t.Fatalf("expected 1 zone, got %d", len(dst.Zones))
+	}
+	z := dst.Zones[0]
+	if z.NrInactiveAnon == nil || *z.NrInactiveAnon != 1 {
+		t.Errorf("expected NrInactiveAnon 1, got %v", z.NrInactiveAnon)
+	}
+	if z.NrActiveAnon == nil || *z.NrActiveAnon != 2 {
+		t.Errorf("expected NrActiveAnon 2, got %v", z.NrActiveAnon)
+	}
+	if z.NrInactiveFile == nil || *z.NrInactiveFile != 3 {
+		t.Errorf("expected NrInactiveFile 3, got %v", z.NrInactiveFile)
+	}
+	if z.NrActiveFile == nil || *z.NrActiveFile != 4 {
+		t.Errorf("expected NrActiveFile 4, got %v", z.NrActiveFile)
+	}
+	if z.NrUnevictable == nil || *z.NrUnevictable != 5 {
+		t.Errorf("expected NrUnevictable 5, got %v", z.NrUnevictable)
+	}
+	if len(dst.Nodes) != 0 {
+		t.Errorf("expected no per-node stats section to be parsed, got %d nodes", len(dst.Nodes))
+	}
+}
+
+// writeSyntheticZoneinfo writes a /proc/zoneinfo-shaped fixture with
+// numNodes nodes of zonesPerNode zones each to procPath, large enough (the
+// real file is typically 1-4 nodes) to make the cost of allocating a fresh
+// ZoneStats/NodeStats/*int64 per field on every call actually show up in a
+// benchmark.
+func writeSyntheticZoneinfo(b *testing.B, procPath string, numNodes, zonesPerNode int) {
+	b.Helper()
+
+	var sb strings.Builder
+	for n := 0; n < numNodes; n++ {
+		for z := 0; z < zonesPerNode; z++ {
+			fmt.Fprintf(&sb, "Node %d, zone   Zone%d\n", n, z)
+			sb.WriteString("  pages free     3952\n" +
+				"        min      33\n" +
+				"        low      41\n" +
+				"        high     49\n" +
+				"        spanned  4095\n" +
+				"        present  3975\n" +
+				"        managed  3956\n" +
+				"        nr_free_pages 3952\n" +
+				"        nr_zone_inactive_anon 0\n" +
+				"        nr_zone_active_anon 0\n" +
+				"        nr_zone_inactive_file 0\n" +
+				"        nr_zone_active_file 0\n" +
+				"        nr_zone_unevictable 0\n" +
+				"        nr_zone_write_pending 0\n" +
+				"        nr_mlock     0\n" +
+				"        nr_page_table_pages 0\n" +
+				"        nr_kernel_stack 0\n" +
+				"        nr_bounce    0\n" +
+				"        nr_zspages   0\n" +
+				"        nr_free_cma  0\n" +
+				"        numa_hit     1\n" +
+				"        numa_miss    0\n" +
+				"        numa_foreign 0\n" +
+				"        numa_interleave 0\n" +
+				"        numa_local   1\n" +
+				"        numa_other   0\n" +
+				"        protection: (0, 2877, 7826, 7826, 7826)\n")
+		}
+		sb.WriteString("  per-node stats\n" +
+			"        nr_inactive_anon 230981\n" +
+			"        nr_active_anon 547580\n" +
+			"        nr_inactive_file 316904\n" +
+			"        nr_active_file 346282\n" +
+			"        nr_unevictable 115467\n" +
+			"        nr_slab_reclaimable 131220\n" +
+			"        nr_slab_unreclaimable 47320\n" +
+			"        nr_isolated_anon 0\n" +
+			"        nr_isolated_file 0\n" +
+			"        workingset_refault 466886\n" +
+			"        workingset_activate 276925\n" +
+			"        workingset_nodereclaim 487\n" +
+			"        nr_anon_pages 795576\n" +
+			"        nr_mapped    215483\n" +
+			"        nr_file_pages 761874\n" +
+			"        nr_dirty     908\n" +
+			"        nr_writeback 0\n" +
+			"        nr_writeback_temp 0\n" +
+			"        nr_shmem     224925\n" +
+			"        nr_shmem_hugepages 0\n" +
+			"        nr_shmem_pmdmapped 0\n" +
+			"        nr_anon_transparent_hugepages 0\n" +
+			"        nr_unstable  0\n" +
+			"        nr_vmscan_write 12950\n" +
+			"        nr_vmscan_immediate_reclaim 3033\n" +
+			"        nr_dirtied   8007423\n" +
+			"        nr_written   7752121\n")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(procPath, "zoneinfo"), []byte(sb.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// benchmarkFS returns an FS over a synthetic zoneinfo fixture with 8 nodes x
+// 5 zones each, the scale a host with multiple NUMA nodes and the usual
+// DMA/DMA32/Normal/Movable/Device zone set actually produces, so the
+// per-field/per-zone allocation cost Zoneinfo pays (and ZoneinfoInto
+// avoids) is large enough to show up in the reported allocs/op.
+func benchmarkFS(b *testing.B) FS {
+	b.Helper()
+
+	procPath := b.TempDir()
+	writeSyntheticZoneinfo(b, procPath, 8, 5)
+	fs, err := NewFS(procPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return fs
+}
+
+func BenchmarkZoneinfo(b *testing.B) {
+	fs := benchmarkFS(b)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Zoneinfo(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestZoneinfoPressure(t *testing.T) {
+	zi := Zoneinfo{
+		Zones: []*ZoneStats{
+			{
+				Node: "0", Zone: "Normal",
+				Free: newPInt64(100), Min: newPInt64(100), Low: newPInt64(200), High: newPInt64(300),
+				Managed: newPInt64(1000),
+			},
+			{
+				Node: "0", Zone: "DMA32",
+				Free: newPInt64(1000), Min: newPInt64(100), Low: newPInt64(200), High: newPInt64(300),
+				Managed: newPInt64(1000),
+			},
+			{
+				// Free below Min: the raw (low+boost-free)/(low+boost-min)
+				// ratio overshoots 1 (here 1.5) and must be clamped.
+				Node: "0", Zone: "DMA",
+				Free: newPInt64(50), Min: newPInt64(100), Low: newPInt64(200), High: newPInt64(300),
+				Managed: newPInt64(1000),
+			},
+			{
+				// No watermarks parsed (e.g. an unpopulated zone); must be skipped.
+				Node: "1", Zone: "Movable",
+			},
+		},
+	}
+
+	pressure := zi.Pressure()
+
+	if len(pressure.Zones) != 3 {
+		t.Fatalf("expected 3 zones with pressure data, got %d", len(pressure.Zones))
+	}
+	if got := *pressure.Zones[0].MemoryPressure; got != 1 {
+		t.Errorf("expected node 0/Normal to be at max pressure, got %v", got)
+	}
+	if got := *pressure.Zones[1].MemoryPressure; got != 0 {
+		t.Errorf("expected node 0/DMA32 to be at no pressure, got %v", got)
+	}
+	if got := *pressure.Zones[2].MemoryPressure; got != 1 {
+		t.Errorf("expected node 0/DMA (Free < Min) to clamp to max pressure, not overshoot, got %v", got)
+	}
+
+	if len(pressure.Nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(pressure.Nodes))
+	}
+	if got := *pressure.Nodes[0].MemoryPressure; got != 1 {
+		t.Errorf("expected node 0's pressure to be the max of its zones, got %v", got)
+	}
+}
+
+func BenchmarkZoneinfoInto(b *testing.B) {
+	fs := benchmarkFS(b)
+
+	var (
+		zoneinfo Zoneinfo
+		buf      ZoneinfoBuf
+	)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := fs.ZoneinfoInto(&zoneinfo, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}